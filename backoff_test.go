@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitterWithinBounds(t *testing.T) {
+	d := 10 * time.Second
+	for i := 0; i < 1000; i++ {
+		got := jitter(d)
+		if got < d/2 || got >= d/2+d {
+			t.Fatalf("jitter(%s) = %s, want in [%s, %s)", d, got, d/2, d/2+d)
+		}
+	}
+}
+
+func TestNextBackoffDoublesUpToMax(t *testing.T) {
+	max := 5 * time.Second
+	d := 500 * time.Millisecond
+
+	d = nextBackoff(d, max)
+	if want := 1 * time.Second; d != want {
+		t.Fatalf("nextBackoff = %s, want %s", d, want)
+	}
+
+	d = nextBackoff(d, max)
+	if want := 2 * time.Second; d != want {
+		t.Fatalf("nextBackoff = %s, want %s", d, want)
+	}
+}
+
+func TestNextBackoffCapsAtMax(t *testing.T) {
+	max := 5 * time.Second
+	d := 4 * time.Second
+
+	if got := nextBackoff(d, max); got != max {
+		t.Fatalf("nextBackoff = %s, want capped at %s", got, max)
+	}
+}
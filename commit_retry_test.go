@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"mdc_stocktake/inventory"
+)
+
+type fakeTx struct {
+	failUpsert error
+}
+
+func (t *fakeTx) Upsert(ctx context.Context, loc, code string, delta int) error {
+	return t.failUpsert
+}
+func (t *fakeTx) Commit() error   { return nil }
+func (t *fakeTx) Rollback() error { return nil }
+
+// fakeStore lets commitChunkWithRetry be exercised without a live DB:
+// Begin fails `failures` times before succeeding, and Retryable reports
+// those failures as transient only when `retryable` is set.
+type fakeStore struct {
+	failures  int
+	attempts  int
+	retryable bool
+}
+
+func (s *fakeStore) Begin(ctx context.Context) (inventory.Tx, error) {
+	s.attempts++
+	if s.attempts <= s.failures {
+		return nil, errors.New("transient failure")
+	}
+	return &fakeTx{}, nil
+}
+
+func (s *fakeStore) History(ctx context.Context, loc string) ([]inventory.Record, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) Retryable(err error) bool { return s.retryable }
+func (s *fakeStore) Close() error             { return nil }
+
+func testContext(store inventory.Store) *Context {
+	return &Context{
+		ctx_store:  store,
+		ctx_logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestCommitChunkWithRetrySucceedsAfterTransientFailures(t *testing.T) {
+	store := &fakeStore{failures: 2, retryable: true}
+	ctx := testContext(store)
+
+	batch := []*transaction{{"A1", "SKU1", 1}}
+	if err := commitChunkWithRetry(ctx, batch); err != nil {
+		t.Fatalf("commitChunkWithRetry: %v", err)
+	}
+	if store.attempts != 3 {
+		t.Fatalf("attempts = %d, want 3", store.attempts)
+	}
+}
+
+func TestCommitChunkWithRetryReturnsImmediatelyOnFatalError(t *testing.T) {
+	store := &fakeStore{failures: 1, retryable: false}
+	ctx := testContext(store)
+
+	batch := []*transaction{{"A1", "SKU1", 1}}
+	if err := commitChunkWithRetry(ctx, batch); err == nil {
+		t.Fatal("commitChunkWithRetry: want error, got nil")
+	}
+	if store.attempts != 1 {
+		t.Fatalf("attempts = %d, want 1 (no retry on non-retryable error)", store.attempts)
+	}
+}
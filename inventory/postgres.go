@@ -0,0 +1,104 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// postgresRetryableCodes are the SQLSTATE classes worth retrying: lock
+// waits and serialization conflicts under concurrent writers, plus the
+// server momentarily refusing new connections.
+var postgresRetryableCodes = map[pq.ErrorCode]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections
+	"55P03": true, // lock_not_available
+}
+
+const (
+	postgresSchema = `CREATE TABLE IF NOT EXISTS inventory (
+		item_location TEXT NOT NULL,
+		item_code     TEXT NOT NULL,
+		item_soh      INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (item_location, item_code)
+	)`
+	postgresUpsertQuery = `INSERT INTO inventory (item_location, item_code, item_soh)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (item_location, item_code)
+		DO UPDATE SET item_soh = inventory.item_soh + EXCLUDED.item_soh`
+	postgresHistoryQuery = "SELECT item_location, item_code, item_soh FROM inventory WHERE item_location = $1 OR $1 = ''"
+)
+
+type postgresStore struct {
+	db *sql.DB
+}
+
+// openPostgres creates the inventory table on open, same as openSQLite,
+// so the two Store implementations are actually interchangeable: a
+// fresh DSN works without a separate migration step.
+func openPostgres(dsn string) (Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(postgresSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &postgresStore{db: db}, nil
+}
+
+func (s *postgresStore) Begin(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, postgresUpsertQuery)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &postgresTx{tx: tx, stmt: stmt}, nil
+}
+
+func (s *postgresStore) History(ctx context.Context, loc string) ([]Record, error) {
+	rows, err := s.db.QueryContext(ctx, postgresHistoryQuery, loc)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRecords(rows)
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *postgresStore) Retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return postgresRetryableCodes[pqErr.Code]
+}
+
+type postgresTx struct {
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+func (t *postgresTx) Upsert(ctx context.Context, loc, code string, delta int) error {
+	_, err := t.stmt.ExecContext(ctx, loc, code, delta)
+	return err
+}
+
+func (t *postgresTx) Commit() error   { return t.tx.Commit() }
+func (t *postgresTx) Rollback() error { return t.tx.Rollback() }
@@ -0,0 +1,28 @@
+package inventory
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestPostgresStoreRetryableKnownCodes(t *testing.T) {
+	s := &postgresStore{}
+	for code := range postgresRetryableCodes {
+		err := &pq.Error{Code: code}
+		if !s.Retryable(err) {
+			t.Errorf("Retryable(%v) = false, want true", code)
+		}
+	}
+}
+
+func TestPostgresStoreRetryableNonTransient(t *testing.T) {
+	s := &postgresStore{}
+	if s.Retryable(&pq.Error{Code: "23505"}) { // unique_violation
+		t.Error("Retryable(23505) = true, want false")
+	}
+	if s.Retryable(errors.New("not a pq error")) {
+		t.Error("Retryable(plain error) = true, want false")
+	}
+}
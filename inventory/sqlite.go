@@ -0,0 +1,98 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	sqliteSchema = `CREATE TABLE IF NOT EXISTS inventory (
+		item_location TEXT NOT NULL,
+		item_code     TEXT NOT NULL,
+		item_soh      INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (item_location, item_code)
+	)`
+	sqliteUpsertQuery  = "INSERT INTO inventory(item_location, item_code, item_soh) VALUES (?, ?, ?) ON CONFLICT(item_location, item_code) DO UPDATE SET item_soh = item_soh + ?"
+	sqliteHistoryQuery = "SELECT item_location, item_code, item_soh FROM inventory WHERE item_location = ? OR ? = ''"
+)
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// openSQLite enables WAL-mode journaling and a busy timeout so this
+// store can share its file with another *sql.DB without the
+// rollback-journal locking that made concurrent writers throw
+// SQLITE_BUSY immediately.
+// SetMaxOpenConns(1) keeps writes serialized through this process too,
+// since go-sqlite3 connections don't share a single OS-level lock.
+func openSQLite(path string) (Store, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Begin(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, sqliteUpsertQuery)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &sqliteTx{tx: tx, stmt: stmt}, nil
+}
+
+func (s *sqliteStore) History(ctx context.Context, loc string) ([]Record, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteHistoryQuery, loc, loc)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRecords(rows)
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// Retryable treats SQLITE_BUSY and SQLITE_LOCKED as transient: another
+// connection (a concurrent writer on the same file, or a reader holding
+// a shared lock) is expected to let go shortly.
+func (s *sqliteStore) Retryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+type sqliteTx struct {
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+func (t *sqliteTx) Upsert(ctx context.Context, loc, code string, delta int) error {
+	_, err := t.stmt.ExecContext(ctx, loc, code, delta, delta)
+	return err
+}
+
+func (t *sqliteTx) Commit() error   { return t.tx.Commit() }
+func (t *sqliteTx) Rollback() error { return t.tx.Rollback() }
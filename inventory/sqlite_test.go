@@ -0,0 +1,28 @@
+package inventory
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestSQLiteStoreRetryableBusyAndLocked(t *testing.T) {
+	s := &sqliteStore{}
+	for _, code := range []sqlite3.ErrNo{sqlite3.ErrBusy, sqlite3.ErrLocked} {
+		err := sqlite3.Error{Code: code}
+		if !s.Retryable(err) {
+			t.Errorf("Retryable(%v) = false, want true", err)
+		}
+	}
+}
+
+func TestSQLiteStoreRetryableNonTransient(t *testing.T) {
+	s := &sqliteStore{}
+	if s.Retryable(sqlite3.Error{Code: sqlite3.ErrConstraint}) {
+		t.Error("Retryable(ErrConstraint) = true, want false")
+	}
+	if s.Retryable(errors.New("not a sqlite error")) {
+		t.Error("Retryable(plain error) = true, want false")
+	}
+}
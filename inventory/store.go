@@ -0,0 +1,54 @@
+// Package inventory holds the counted-item storage backend. It used to
+// be a single hard-coded SQLite connection in main.go; it's now an
+// interface with SQLite and Postgres implementations so that a fleet of
+// handheld stations can point at one shared database instead of merging
+// local SQLite files after the fact.
+package inventory
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Record is one row of the inventory table: the on-hand count currently
+// recorded for an item at a location.
+type Record struct {
+	Location string
+	Code     string
+	Soh      int
+}
+
+// Store is the storage backend for counted inventory. Open selects an
+// implementation from a DSN.
+type Store interface {
+	// Begin starts a batch of upserts that commit together, mirroring
+	// how StoreTransactions drains the transaction channel.
+	Begin(ctx context.Context) (Tx, error)
+	// History returns every recorded count for loc, or for every
+	// location if loc is empty.
+	History(ctx context.Context, loc string) ([]Record, error)
+	// Retryable reports whether err is a transient failure (lock
+	// contention, serialization conflict, ...) worth retrying rather
+	// than a fatal one.
+	Retryable(err error) bool
+	Close() error
+}
+
+// Tx accumulates item count deltas for a single commit.
+type Tx interface {
+	Upsert(ctx context.Context, loc, code string, delta int) error
+	Commit() error
+	Rollback() error
+}
+
+func scanRecords(rows *sql.Rows) ([]Record, error) {
+	var records []Record
+	for rows.Next() {
+		var r Record
+		if err := rows.Scan(&r.Location, &r.Code, &r.Soh); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,118 @@
+// Package logging gives the stocktake CLI one structured event stream
+// instead of the old pair of ad-hoc log.Print and fmt.Printf call sites.
+// Every event is logged once, through log/slog, and fanned out to two
+// handlers: JSON lines (with ts/level/event/location/code/soh/sender
+// fields) rotated to disk via lumberjack, and a short human-readable
+// line rendered from that same record for the terminal. The two can't
+// drift, because the CLI text is derived from the structured event
+// rather than written alongside it.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// New builds the stocktake logger. path is rotated by lumberjack;
+// human receives one rendered line per event (typically os.Stdout).
+func New(path string, human io.Writer) (*slog.Logger, *lumberjack.Logger) {
+	rotator := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    10, // megabytes
+		MaxBackups: 5,
+		MaxAge:     28, // days
+		Compress:   true,
+	}
+
+	handler := teeHandler{
+		structured: slog.NewJSONHandler(rotator, &slog.HandlerOptions{ReplaceAttr: renameStandardKeys}),
+		human:      humanHandler{w: human},
+	}
+
+	return slog.New(handler), rotator
+}
+
+// renameStandardKeys maps slog's default key names onto the ones the
+// stocktake event schema actually promises: "ts" for the timestamp and
+// "event" for the message, so a downstream collector built against that
+// schema doesn't have to know it's looking at slog.JSONHandler output.
+func renameStandardKeys(groups []string, a slog.Attr) slog.Attr {
+	switch a.Key {
+	case slog.TimeKey:
+		a.Key = "ts"
+	case slog.MessageKey:
+		a.Key = "event"
+	}
+	return a
+}
+
+// teeHandler dispatches every record to both the structured and the
+// human handler, so a single log call produces both representations.
+type teeHandler struct {
+	structured slog.Handler
+	human      slog.Handler
+}
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	if err := t.structured.Handle(ctx, r.Clone()); err != nil {
+		return err
+	}
+	return t.human.Handle(ctx, r.Clone())
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return teeHandler{structured: t.structured.WithAttrs(attrs), human: t.human.WithAttrs(attrs)}
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	return teeHandler{structured: t.structured.WithGroup(name), human: t.human.WithGroup(name)}
+}
+
+// humanHandler renders a record the way the CLI always has: a bracketed
+// level, the event message, and any fields attached to it.
+type humanHandler struct {
+	w     io.Writer
+	attrs []slog.Attr
+}
+
+func (h humanHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h humanHandler) Handle(ctx context.Context, r slog.Record) error {
+	level := "INFO"
+	if r.Level >= slog.LevelError {
+		level = "ERROR"
+	}
+
+	line := fmt.Sprintf("[%s] %s", level, r.Message)
+	for _, a := range h.attrs {
+		line += fmt.Sprintf(" %s=%v", a.Key, a.Value)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		line += fmt.Sprintf(" %s=%v", a.Key, a.Value)
+		return true
+	})
+
+	_, err := fmt.Fprintln(h.w, line)
+	return err
+}
+
+func (h humanHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	merged := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	merged = append(merged, h.attrs...)
+	merged = append(merged, attrs...)
+	return humanHandler{w: h.w, attrs: merged}
+}
+
+func (h humanHandler) WithGroup(name string) slog.Handler {
+	return h
+}
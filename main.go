@@ -1,307 +1,699 @@
-package main
-
-import (
-	"bufio"
-	"database/sql"
-	"encoding/json"
-	"fmt"
-	"log"
-	"net"
-	"os"
-	"regexp"
-	"strconv"
-	"sync"
-
-	_ "github.com/mattn/go-sqlite3"
-)
-
-const (
-	MDC_ST_LOG_FILEPATH = "./mdc_stocktake.log"
-	MDC_ST_DB_FILEPATH  = "./mdc_inventory.db"
-	MDC_ST_LOC_PATTERN  = "^[A-W]\\d{1,2}$"
-	MDC_ST_SOH_PATTERN  = "^\\d{1,3}$"
-
-	MDC_ST_DB_QUERY = "INSERT INTO inventory(item_location, item_code, item_soh) VALUES (?, ?, ?) ON CONFLICT(item_location, item_code) DO UPDATE SET item_soh = item_soh + ?"
-
-	MDC_ST_ALLAH_ADDRESS = "10.4.0.214:5467"
-
-	MDC_ST_CLI_PROMPT = "MDC_ST $"
-)
-
-type transaction struct {
-	location string
-	code     string
-	soh      int
-}
-
-var (
-	END_OF_TRANSACTIONS = transaction{
-		"",
-		"",
-		-1,
-	}
-)
-
-type prayer struct {
-	Sender   string `json:"Sender"`
-	Location string `json:"Location"`
-	Code     string `json:"Code"`
-	Soh      int    `json:"Soh"`
-}
-
-func NewPrayer(loc string, code string, soh int) prayer {
-	name, _ := os.Hostname()
-	return prayer{
-		Sender:   name,
-		Location: loc,
-		Code:     code,
-		Soh:      soh,
-	}
-}
-
-func TalkWithGod(ctx *Context) {
-	ctx.ctx_dbwait.Add(1)
-	defer ctx.ctx_dbwait.Done()
-
-	conn, err := net.Dial("tcp", MDC_ST_ALLAH_ADDRESS)
-	if err != nil {
-		log.Print("[ERROR] Could not reach god, must be busy...")
-		close(ctx.ctx_allah_chan)
-		ctx.ctx_allah_online = false
-		return
-	}
-	ctx.ctx_allah_online = true
-
-	for c := range ctx.ctx_allah_chan {
-		if *c == END_OF_TRANSACTIONS {
-			break
-		}
-
-		p := NewPrayer(c.location, c.code, c.soh)
-		prayer, err := json.Marshal(p)
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-
-		conn.Write(prayer)
-	}
-	conn.Close()
-	close(ctx.ctx_allah_chan)
-}
-
-type Context struct {
-	ctx_dbconn  *sql.DB
-	ctx_running bool
-
-	ctx_logfile *os.File
-
-	ctx_loc_finder *regexp.Regexp
-	ctx_soh_finder *regexp.Regexp
-
-	ctx_current_loc  string
-	ctx_current_code string
-	ctx_history      []*transaction
-
-	ctx_transaction_chan chan *transaction
-	ctx_allah_chan       chan *transaction
-	ctx_dbwait           *sync.WaitGroup
-
-	ctx_allah_online bool
-}
-
-func GenContext() Context {
-	ctx := Context{}
-
-	ctx.ctx_current_loc = ""
-	ctx.ctx_current_code = ""
-	ctx.ctx_history = make([]*transaction, 0)
-	ctx.ctx_transaction_chan = make(chan *transaction)
-	ctx.ctx_allah_chan = make(chan *transaction)
-	ctx.ctx_running = true
-	ctx.ctx_dbwait = &sync.WaitGroup{}
-
-	logfile, err := os.OpenFile(MDC_ST_LOG_FILEPATH, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.SetOutput(logfile)
-
-	db, err := sql.Open("sqlite3", MDC_ST_DB_FILEPATH)
-	if err != nil {
-		log.Fatalf("could not open database file \"%s\".", MDC_ST_DB_FILEPATH)
-	}
-	ctx.ctx_dbconn = db
-
-	r, err := regexp.Compile(MDC_ST_LOC_PATTERN)
-	if err != nil {
-		log.Fatalf("could not compile regex for location \"%s\".", MDC_ST_LOC_PATTERN)
-	}
-	ctx.ctx_loc_finder = r
-
-	r, err = regexp.Compile(MDC_ST_SOH_PATTERN)
-	if err != nil {
-		log.Fatalf("could not compile regex for soh \"%s\".", MDC_ST_SOH_PATTERN)
-	}
-	ctx.ctx_soh_finder = r
-
-	return ctx
-}
-
-func SubmitTransaction(ctx *Context, loc string, code string, soh int) {
-	count := &transaction{
-		loc,
-		code,
-		soh,
-	}
-
-	log.Printf("[INFO] Submit count to database (%s, %s, %d)", loc, code, soh)
-	fmt.Printf("[INFO] Submit count to database (%s, %s, %d)\n", loc, code, soh)
-	ctx.ctx_history = append(ctx.ctx_history, count)
-	ctx.ctx_current_code = ""
-
-	if ctx.ctx_allah_online {
-		ctx.ctx_allah_chan <- count
-	}
-	ctx.ctx_transaction_chan <- count
-}
-
-func UndoTransaction(ctx *Context) {
-	var c *transaction
-	c, ctx.ctx_history = ctx.ctx_history[len(ctx.ctx_history)-1], ctx.ctx_history[:len(ctx.ctx_history)-1]
-
-	log.Printf("[INFO] Reverting transaction (%s, %s, %d)", c.location, c.code, c.soh)
-	fmt.Printf("[INFO] Reverting transaction (%s, %s, %d)\n", c.location, c.code, c.soh)
-	SubmitTransaction(ctx, c.location, c.code, -c.soh)
-}
-
-func StoreTransactions(ctx *Context) {
-	ctx.ctx_dbwait.Add(1)
-
-	tx, err := ctx.ctx_dbconn.Begin()
-	if err != nil {
-		log.Print(err)
-		return
-	}
-
-	add_stmt, err := tx.Prepare(MDC_ST_DB_QUERY)
-	if err != nil {
-		log.Print(err)
-		return
-	}
-
-	for count := range ctx.ctx_transaction_chan {
-		if *count == END_OF_TRANSACTIONS {
-			log.Print("[INFO] Got end of transactions, closing connection to database")
-			break
-		}
-
-		_, err = add_stmt.Exec(count.location, count.code, count.soh, count.soh)
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		log.Print(err)
-	}
-
-	ctx.ctx_dbwait.Done()
-}
-
-func DestroyContext(ctx *Context) {
-	if ctx == nil {
-		return
-	}
-
-	log.Print("[INFO] Destroying context, sending end of transactions...")
-	ctx.ctx_transaction_chan <- &END_OF_TRANSACTIONS
-	if ctx.ctx_allah_online {
-		ctx.ctx_allah_chan <- &END_OF_TRANSACTIONS
-	}
-	close(ctx.ctx_transaction_chan)
-	ctx.ctx_dbwait.Wait()
-	ctx.ctx_logfile.Close()
-}
-
-func ProcessInput(ctx *Context, user_input string) {
-	if user_input == "exit" {
-		if ctx.ctx_current_loc != "" && ctx.ctx_current_code != "" {
-			SubmitTransaction(ctx, ctx.ctx_current_loc, ctx.ctx_current_code, 1)
-		}
-		ctx.ctx_running = false
-		return
-	} else if user_input == "undo" {
-		if len(ctx.ctx_history) != 0 {
-			UndoTransaction(ctx)
-		} else {
-			log.Print("[INFO] No more transactions to revert")
-			println("[INFO] No more transactions to revert")
-		}
-		return
-	}
-
-	if ctx.ctx_loc_finder.MatchString(user_input) {
-		if ctx.ctx_current_loc != "" && ctx.ctx_current_code != "" {
-			SubmitTransaction(ctx, ctx.ctx_current_loc, ctx.ctx_current_code, 1)
-		}
-		// TODO: this should be a single function call
-		log.Printf("[INFO] Location changed from \"%s\" to \"%s\"", ctx.ctx_current_loc, user_input)
-		fmt.Printf("[INFO] Location changed from \"%s\" to \"%s\"\n", ctx.ctx_current_loc, user_input)
-		ctx.ctx_current_loc = user_input
-		return
-	} else if ctx.ctx_soh_finder.MatchString(user_input) {
-		if ctx.ctx_current_loc == "" {
-			log.Print("[ERROR] You need to set a location before providing a quantity")
-			println("[ERROR] You need to set a location before providing a quantity")
-			return
-		}
-
-		if ctx.ctx_current_code == "" {
-			log.Print("[ERROR] You need to provide an item code before providing a quantity")
-			println("[ERROR] You need to provide an item code before providing a quantity")
-			return
-		}
-
-		i, _ := strconv.Atoi(user_input) // FIXME: we should care about errors
-		SubmitTransaction(ctx, ctx.ctx_current_loc, ctx.ctx_current_code, i)
-		return
-	} else {
-		if ctx.ctx_current_loc == "" {
-			log.Print("[ERROR] You need to provide a location before providing an item code.")
-			println("[ERROR] You need to provide a location before providing an item code.")
-			return
-		}
-
-		if ctx.ctx_current_code != "" {
-			SubmitTransaction(ctx, ctx.ctx_current_loc, ctx.ctx_current_code, 1)
-		}
-
-		ctx.ctx_current_code = user_input
-		return
-	}
-}
-
-func main() {
-	ctx := GenContext()
-	scanner := bufio.NewScanner(os.Stdin)
-
-	go TalkWithGod(&ctx)
-	go StoreTransactions(&ctx)
-	defer DestroyContext(&ctx)
-
-	for ctx.ctx_running {
-		print(MDC_ST_CLI_PROMPT)
-		scanner.Scan()
-
-		if scanner.Err() != nil {
-			log.Fatal("could not take user input.")
-		}
-
-		ProcessInput(&ctx, scanner.Text())
-	}
-
-	println("[INFO] Closing stocktake, your data is safe :^)")
-}
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/mattn/go-sqlite3"
+
+	"mdc_stocktake/inventory"
+	"mdc_stocktake/logging"
+	"mdc_stocktake/prayers"
+)
+
+const (
+	MDC_ST_LOG_FILEPATH = "./mdc_stocktake.log"
+	MDC_ST_DB_FILEPATH  = "./mdc_inventory.db"
+	MDC_ST_WAL_FILEPATH = "./mdc_prayers_wal.db"
+	MDC_ST_LOC_PATTERN  = "^[A-W]\\d{1,2}$"
+	MDC_ST_SOH_PATTERN  = "^\\d{1,3}$"
+
+	MDC_ST_DB_DSN_ENV     = "MDC_ST_DB_DSN"
+	MDC_ST_DEFAULT_DB_DSN = "sqlite://" + MDC_ST_DB_FILEPATH
+
+	MDC_ST_WAL_SCHEMA = `CREATE TABLE IF NOT EXISTS pending_prayers (
+		seq      INTEGER PRIMARY KEY AUTOINCREMENT,
+		sender   TEXT NOT NULL,
+		payload  TEXT NOT NULL,
+		acked    INTEGER NOT NULL DEFAULT 0
+	)`
+	MDC_ST_WAL_INSERT = "INSERT INTO pending_prayers(sender, payload) VALUES (?, ?)"
+	MDC_ST_WAL_SELECT = "SELECT seq, sender, payload FROM pending_prayers WHERE acked = 0 ORDER BY seq LIMIT ?"
+	MDC_ST_WAL_ACK    = "DELETE FROM pending_prayers WHERE seq <= ?"
+
+	MDC_ST_ALLAH_ADDRESS = "10.4.0.214:5467"
+
+	MDC_ST_ALLAH_BATCH_SIZE    = 64
+	MDC_ST_ALLAH_IO_TIMEOUT    = 10 * time.Second
+	MDC_ST_ALLAH_POLL_INTERVAL = 2 * time.Second
+	MDC_ST_ALLAH_BACKOFF_MIN   = 500 * time.Millisecond
+	MDC_ST_ALLAH_BACKOFF_MAX   = 30 * time.Second
+
+	MDC_ST_FRAME_MAX_BYTES = 4 << 20 // guard against a corrupt/hostile length prefix
+
+	MDC_ST_COMMIT_CHUNK_SIZE  = 100
+	MDC_ST_COMMIT_INTERVAL    = 1 * time.Second
+	MDC_ST_RETRY_BACKOFF_MIN  = 100 * time.Millisecond
+	MDC_ST_RETRY_BACKOFF_MAX  = 5 * time.Second
+	MDC_ST_RETRY_MAX_ATTEMPTS = 8
+
+	MDC_ST_CLI_PROMPT = "MDC_ST $"
+)
+
+type transaction struct {
+	location string
+	code     string
+	soh      int
+}
+
+var (
+	END_OF_TRANSACTIONS = transaction{
+		"",
+		"",
+		-1,
+	}
+)
+
+func NewPrayer(loc string, code string, soh int) prayers.Prayer {
+	name, _ := os.Hostname()
+	return prayers.New(name, loc, code, soh)
+}
+
+// prayerBatch is the body of a single framed write to god: every prayer
+// still waiting in the WAL, along with the sequence numbers so the ack
+// can tell us which rows are safe to delete.
+type prayerBatch struct {
+	Seqs    []int64          `json:"Seqs"`
+	Prayers []prayers.Prayer `json:"Prayers"`
+}
+
+// prayerAck is the body god sends back once a batch has been durably
+// received. Seq is the highest sequence number covered by the ack;
+// everything up to and including it is deleted from the WAL.
+type prayerAck struct {
+	Seq int64 `json:"Ack"`
+}
+
+type pendingPrayer struct {
+	seq     int64
+	sender  string
+	payload []byte
+}
+
+// walRetryable reports whether err is a transient SQLITE_BUSY/LOCKED
+// error from the WAL connection — the same condition the SQLite
+// inventory.Store's Retryable treats as worth retrying. The WAL has its
+// own file, separate from the inventory store's default file, so the
+// two pools do not contend for one lock by default.
+func walRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// withWALRetry retries fn on transient WAL lock contention with the
+// same capped backoff+jitter commitChunkWithRetry uses against the
+// inventory store, so a brief lock on the WAL file does not drop a
+// prayer on the first failed attempt.
+func withWALRetry(ctx *Context, op string, fn func() error) error {
+	backoff := MDC_ST_RETRY_BACKOFF_MIN
+
+	var err error
+	for attempt := 1; attempt <= MDC_ST_RETRY_MAX_ATTEMPTS; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		if !walRetryable(err) {
+			return err
+		}
+
+		ctx.ctx_logger.Error("wal_retry", "op", op, "attempt", attempt, "max_attempts", MDC_ST_RETRY_MAX_ATTEMPTS, "err", err)
+		time.Sleep(jitter(backoff))
+		backoff = nextBackoff(backoff, MDC_ST_RETRY_BACKOFF_MAX)
+	}
+
+	return err
+}
+
+// enqueuePrayer durably records a prayer in the pending_prayers WAL and
+// wakes TalkWithGod if it's waiting for work. It never touches the
+// network, so a submit can never block on god being unreachable.
+func enqueuePrayer(ctx *Context, p prayers.Prayer) error {
+	payload, err := prayers.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	err = withWALRetry(ctx, "enqueue", func() error {
+		_, err := ctx.ctx_dbconn.Exec(MDC_ST_WAL_INSERT, p.Sender, string(payload))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case ctx.ctx_allah_notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func loadPending(ctx *Context, limit int) ([]pendingPrayer, error) {
+	var rows *sql.Rows
+	err := withWALRetry(ctx, "load", func() error {
+		var err error
+		rows, err = ctx.ctx_dbconn.Query(MDC_ST_WAL_SELECT, limit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make([]pendingPrayer, 0, limit)
+	for rows.Next() {
+		var pp pendingPrayer
+		var payload string
+		if err := rows.Scan(&pp.seq, &pp.sender, &payload); err != nil {
+			return nil, err
+		}
+		pp.payload = []byte(payload)
+		pending = append(pending, pp)
+	}
+	return pending, rows.Err()
+}
+
+func ackPending(ctx *Context, seq int64) error {
+	return withWALRetry(ctx, "ack", func() error {
+		_, err := ctx.ctx_dbconn.Exec(MDC_ST_WAL_ACK, seq)
+		return err
+	})
+}
+
+func writeFrame(conn net.Conn, body []byte) error {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(body)))
+	if _, err := conn.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := conn.Write(body)
+	return err
+}
+
+func readFrame(conn net.Conn) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > MDC_ST_FRAME_MAX_BYTES {
+		return nil, fmt.Errorf("frame of %d bytes exceeds max of %d", n, MDC_ST_FRAME_MAX_BYTES)
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// decodePending batch-decodes every pending payload through
+// prayers.ParsePrayerBatch in a single call, which is the whole point of
+// the simdjson-go path: one tape walk over the concatenated rows instead
+// of len(pending) individual json.Unmarshal calls. If the batch as a
+// whole fails to parse (or a row goes missing), it falls back to
+// decoding each payload independently so one corrupt row doesn't stall
+// the rest of the WAL.
+func decodePending(ctx *Context, pending []pendingPrayer) prayerBatch {
+	buf := make([]byte, 0, len(pending)*64)
+	buf = append(buf, '[')
+	for i, pp := range pending {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, pp.payload...)
+	}
+	buf = append(buf, ']')
+
+	batch := prayerBatch{}
+
+	if decoded, err := prayers.ParsePrayerBatch(buf); err == nil && len(decoded) == len(pending) {
+		for i, pp := range pending {
+			batch.Seqs = append(batch.Seqs, pp.seq)
+			batch.Prayers = append(batch.Prayers, decoded[i])
+		}
+		return batch
+	}
+
+	for _, pp := range pending {
+		p, err := prayers.Unmarshal(pp.payload)
+		if err != nil {
+			ctx.ctx_logger.Error("prayer_parse_fail", "seq", pp.seq, "err", err)
+			continue
+		}
+		batch.Seqs = append(batch.Seqs, pp.seq)
+		batch.Prayers = append(batch.Prayers, p)
+	}
+	return batch
+}
+
+// drainPending owns a single live connection to god: it batches up
+// whatever is sitting in the WAL, writes one framed message, and waits
+// for the ack before deleting those rows. It returns nil once asked to
+// shut down with nothing left to send, or an error as soon as the
+// connection misbehaves so the caller can redial.
+func drainPending(ctx *Context, conn net.Conn) error {
+	ticker := time.NewTicker(MDC_ST_ALLAH_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		pending, err := loadPending(ctx, MDC_ST_ALLAH_BATCH_SIZE)
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 {
+			select {
+			case <-ctx.ctx_allah_done:
+				return nil
+			case <-ctx.ctx_allah_notify:
+				continue
+			case <-ticker.C:
+				continue
+			}
+		}
+
+		batch := decodePending(ctx, pending)
+
+		body, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(MDC_ST_ALLAH_IO_TIMEOUT))
+		if err := writeFrame(conn, body); err != nil {
+			return err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(MDC_ST_ALLAH_IO_TIMEOUT))
+		ackBody, err := readFrame(conn)
+		if err != nil {
+			return err
+		}
+
+		var ack prayerAck
+		if err := json.Unmarshal(ackBody, &ack); err != nil {
+			return err
+		}
+
+		if err := ackPending(ctx, ack.Seq); err != nil {
+			return err
+		}
+	}
+}
+
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+func nextBackoff(d, max time.Duration) time.Duration {
+	d *= 2
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// sleepOrDone waits out a backoff, returning false early if shutdown was
+// requested so TalkWithGod doesn't keep redialing on the way out.
+func sleepOrDone(ctx *Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.ctx_allah_done:
+		return false
+	}
+}
+
+// TalkWithGod keeps the WAL flowing upstream for as long as the process
+// lives. It never blocks SubmitTransaction: transactions land in the
+// pending_prayers table regardless of whether god is reachable, and this
+// goroutine's only job is to redial with backoff and drain that table
+// whenever a connection is up.
+func TalkWithGod(ctx *Context) {
+	ctx.ctx_dbwait.Add(1)
+	defer ctx.ctx_dbwait.Done()
+
+	backoff := MDC_ST_ALLAH_BACKOFF_MIN
+	for {
+		select {
+		case <-ctx.ctx_allah_done:
+			return
+		default:
+		}
+
+		conn, err := net.Dial("tcp", MDC_ST_ALLAH_ADDRESS)
+		if err != nil {
+			ctx.ctx_logger.Error("upstream_fail", "reason", "dial", "retry_in", backoff.String(), "err", err)
+			ctx.ctx_allah_online.Store(false)
+			if !sleepOrDone(ctx, jitter(backoff)) {
+				return
+			}
+			backoff = nextBackoff(backoff, MDC_ST_ALLAH_BACKOFF_MAX)
+			continue
+		}
+
+		ctx.ctx_logger.Info("upstream_connected")
+		ctx.ctx_allah_online.Store(true)
+		backoff = MDC_ST_ALLAH_BACKOFF_MIN
+
+		err = drainPending(ctx, conn)
+		conn.Close()
+		ctx.ctx_allah_online.Store(false)
+
+		if err == nil {
+			return
+		}
+
+		ctx.ctx_logger.Error("upstream_fail", "reason", "drain", "err", err)
+		if !sleepOrDone(ctx, jitter(backoff)) {
+			return
+		}
+		backoff = nextBackoff(backoff, MDC_ST_ALLAH_BACKOFF_MAX)
+	}
+}
+
+type Context struct {
+	ctx_dbconn  *sql.DB
+	ctx_store   inventory.Store
+	ctx_running bool
+
+	ctx_logger  *slog.Logger
+	ctx_rotator *lumberjack.Logger
+
+	ctx_loc_finder *regexp.Regexp
+	ctx_soh_finder *regexp.Regexp
+
+	ctx_current_loc  string
+	ctx_current_code string
+	ctx_history      []*transaction
+
+	ctx_transaction_chan chan *transaction
+	ctx_dbwait           *sync.WaitGroup
+
+	ctx_allah_notify chan struct{}
+	ctx_allah_done   chan struct{}
+	ctx_allah_online *atomic.Bool
+}
+
+func GenContext(dsn string) Context {
+	ctx := Context{}
+
+	ctx.ctx_current_loc = ""
+	ctx.ctx_current_code = ""
+	ctx.ctx_history = make([]*transaction, 0)
+	ctx.ctx_transaction_chan = make(chan *transaction)
+	ctx.ctx_allah_notify = make(chan struct{}, 1)
+	ctx.ctx_allah_done = make(chan struct{})
+	ctx.ctx_allah_online = &atomic.Bool{}
+	ctx.ctx_running = true
+	ctx.ctx_dbwait = &sync.WaitGroup{}
+
+	logger, rotator := logging.New(MDC_ST_LOG_FILEPATH, os.Stdout)
+	ctx.ctx_logger = logger
+	ctx.ctx_rotator = rotator
+
+	// The WAL lives in its own file, separate from the inventory store's
+	// default file: two *sql.DB pools sharing one sqlite file need
+	// WAL-mode journaling and a busy timeout to avoid SQLITE_BUSY under
+	// concurrent writers, and SetMaxOpenConns(1) keeps this pool's own
+	// writes serialized in the meantime.
+	db, err := sql.Open("sqlite3", MDC_ST_WAL_FILEPATH+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		ctx.ctx_logger.Error("fatal_init", "reason", "wal_open", "path", MDC_ST_WAL_FILEPATH, "err", err)
+		os.Exit(1)
+	}
+	db.SetMaxOpenConns(1)
+	ctx.ctx_dbconn = db
+
+	if _, err := ctx.ctx_dbconn.Exec(MDC_ST_WAL_SCHEMA); err != nil {
+		ctx.ctx_logger.Error("fatal_init", "reason", "wal_schema", "err", err)
+		os.Exit(1)
+	}
+
+	store, err := inventory.Open(dsn)
+	if err != nil {
+		ctx.ctx_logger.Error("fatal_init", "reason", "store_open", "dsn", dsn, "err", err)
+		os.Exit(1)
+	}
+	ctx.ctx_store = store
+
+	r, err := regexp.Compile(MDC_ST_LOC_PATTERN)
+	if err != nil {
+		ctx.ctx_logger.Error("fatal_init", "reason", "loc_pattern", "pattern", MDC_ST_LOC_PATTERN, "err", err)
+		os.Exit(1)
+	}
+	ctx.ctx_loc_finder = r
+
+	r, err = regexp.Compile(MDC_ST_SOH_PATTERN)
+	if err != nil {
+		ctx.ctx_logger.Error("fatal_init", "reason", "soh_pattern", "pattern", MDC_ST_SOH_PATTERN, "err", err)
+		os.Exit(1)
+	}
+	ctx.ctx_soh_finder = r
+
+	return ctx
+}
+
+func SubmitTransaction(ctx *Context, loc string, code string, soh int) {
+	count := &transaction{
+		loc,
+		code,
+		soh,
+	}
+
+	p := NewPrayer(loc, code, soh)
+	ctx.ctx_logger.Info("submit", "location", loc, "code", code, "soh", soh, "sender", p.Sender)
+	ctx.ctx_history = append(ctx.ctx_history, count)
+	ctx.ctx_current_code = ""
+
+	if err := enqueuePrayer(ctx, p); err != nil {
+		ctx.ctx_logger.Error("upstream_fail", "reason", "enqueue", "location", loc, "code", code, "soh", soh, "sender", p.Sender, "err", err)
+	}
+	ctx.ctx_transaction_chan <- count
+}
+
+func UndoTransaction(ctx *Context) {
+	var c *transaction
+	c, ctx.ctx_history = ctx.ctx_history[len(ctx.ctx_history)-1], ctx.ctx_history[:len(ctx.ctx_history)-1]
+
+	ctx.ctx_logger.Info("undo", "location", c.location, "code", c.code, "soh", c.soh)
+	SubmitTransaction(ctx, c.location, c.code, -c.soh)
+}
+
+// commitChunk opens a single transaction, upserts every buffered count
+// into it, and commits. The whole chunk is re-run from the in-memory
+// batch on a transient error, since by the time we'd know to retry the
+// channel has already moved on.
+func commitChunk(ctx *Context, batch []*transaction) error {
+	tx, err := ctx.ctx_store.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, count := range batch {
+		if err := tx.Upsert(context.Background(), count.location, count.code, count.soh); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// commitChunkWithRetry retries commitChunk on transient DB errors
+// (lock contention, serialization conflicts) with capped exponential
+// backoff and jitter. Fatal errors are returned immediately.
+func commitChunkWithRetry(ctx *Context, batch []*transaction) error {
+	backoff := MDC_ST_RETRY_BACKOFF_MIN
+
+	var err error
+	for attempt := 1; attempt <= MDC_ST_RETRY_MAX_ATTEMPTS; attempt++ {
+		err = commitChunk(ctx, batch)
+		if err == nil {
+			return nil
+		}
+
+		if !ctx.ctx_store.Retryable(err) {
+			return err
+		}
+
+		ctx.ctx_logger.Error("store_retry", "batch_size", len(batch), "attempt", attempt, "max_attempts", MDC_ST_RETRY_MAX_ATTEMPTS, "err", err)
+		time.Sleep(jitter(backoff))
+		backoff = nextBackoff(backoff, MDC_ST_RETRY_BACKOFF_MAX)
+	}
+
+	return err
+}
+
+// StoreTransactions drains the transaction channel into fixed-size
+// chunks (by count or by time, whichever comes first) and commits each
+// one independently, so a crash mid-session only loses the chunk that
+// was in flight rather than the whole day's count.
+func StoreTransactions(ctx *Context) {
+	ctx.ctx_dbwait.Add(1)
+	defer ctx.ctx_dbwait.Done()
+
+	var batch []*transaction
+	ticker := time.NewTicker(MDC_ST_COMMIT_INTERVAL)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := commitChunkWithRetry(ctx, batch); err != nil {
+			ctx.ctx_logger.Error("store_fail", "batch_size", len(batch), "err", err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case count, ok := <-ctx.ctx_transaction_chan:
+			if !ok {
+				flush()
+				return
+			}
+			if *count == END_OF_TRANSACTIONS {
+				ctx.ctx_logger.Info("shutdown_drain")
+				flush()
+				return
+			}
+
+			batch = append(batch, count)
+			if len(batch) >= MDC_ST_COMMIT_CHUNK_SIZE {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func DestroyContext(ctx *Context) {
+	if ctx == nil {
+		return
+	}
+
+	ctx.ctx_logger.Info("shutdown")
+	ctx.ctx_transaction_chan <- &END_OF_TRANSACTIONS
+	close(ctx.ctx_transaction_chan)
+	close(ctx.ctx_allah_done)
+	ctx.ctx_dbwait.Wait()
+	if err := ctx.ctx_store.Close(); err != nil {
+		ctx.ctx_logger.Error("store_close_fail", "err", err)
+	}
+	ctx.ctx_rotator.Close()
+}
+
+func ProcessInput(ctx *Context, user_input string) {
+	if user_input == "exit" {
+		if ctx.ctx_current_loc != "" && ctx.ctx_current_code != "" {
+			SubmitTransaction(ctx, ctx.ctx_current_loc, ctx.ctx_current_code, 1)
+		}
+		ctx.ctx_running = false
+		return
+	} else if user_input == "undo" {
+		if len(ctx.ctx_history) != 0 {
+			UndoTransaction(ctx)
+		} else {
+			ctx.ctx_logger.Info("undo_empty")
+		}
+		return
+	}
+
+	if ctx.ctx_loc_finder.MatchString(user_input) {
+		if ctx.ctx_current_loc != "" && ctx.ctx_current_code != "" {
+			SubmitTransaction(ctx, ctx.ctx_current_loc, ctx.ctx_current_code, 1)
+		}
+		// TODO: this should be a single function call
+		ctx.ctx_logger.Info("location_change", "from", ctx.ctx_current_loc, "to", user_input)
+		ctx.ctx_current_loc = user_input
+		return
+	} else if ctx.ctx_soh_finder.MatchString(user_input) {
+		if ctx.ctx_current_loc == "" {
+			ctx.ctx_logger.Error("missing_location")
+			return
+		}
+
+		if ctx.ctx_current_code == "" {
+			ctx.ctx_logger.Error("missing_code")
+			return
+		}
+
+		i, _ := strconv.Atoi(user_input) // FIXME: we should care about errors
+		SubmitTransaction(ctx, ctx.ctx_current_loc, ctx.ctx_current_code, i)
+		return
+	} else {
+		if ctx.ctx_current_loc == "" {
+			ctx.ctx_logger.Error("missing_location_for_code")
+			return
+		}
+
+		if ctx.ctx_current_code != "" {
+			SubmitTransaction(ctx, ctx.ctx_current_loc, ctx.ctx_current_code, 1)
+		}
+
+		ctx.ctx_current_code = user_input
+		return
+	}
+}
+
+func main() {
+	dsn := flag.String("db-dsn", "", "inventory storage DSN (sqlite://path or postgres://...), defaults to $"+MDC_ST_DB_DSN_ENV+" or "+MDC_ST_DEFAULT_DB_DSN)
+	flag.Parse()
+
+	resolvedDSN := *dsn
+	if resolvedDSN == "" {
+		resolvedDSN = os.Getenv(MDC_ST_DB_DSN_ENV)
+	}
+	if resolvedDSN == "" {
+		resolvedDSN = MDC_ST_DEFAULT_DB_DSN
+	}
+
+	ctx := GenContext(resolvedDSN)
+	scanner := bufio.NewScanner(os.Stdin)
+
+	go TalkWithGod(&ctx)
+	go StoreTransactions(&ctx)
+	defer DestroyContext(&ctx)
+
+	for ctx.ctx_running {
+		print(MDC_ST_CLI_PROMPT)
+		scanner.Scan()
+
+		if scanner.Err() != nil {
+			ctx.ctx_logger.Error("fatal_input", "reason", "stdin", "err", scanner.Err())
+			os.Exit(1)
+		}
+
+		ProcessInput(&ctx, scanner.Text())
+	}
+
+	ctx.ctx_logger.Info("shutdown_complete")
+}
@@ -0,0 +1,156 @@
+// Package prayers encodes and decodes the prayer wire format shared with
+// the Allah server. Emission always goes through encoding/json, since a
+// single prayer is tiny and json.Marshal is fast enough for that. Batch
+// decoding (replaying a peer's WAL log, or a future ingest command on the
+// server side of this same binary) is the hot path worth accelerating:
+// ParsePrayerBatch walks the simdjson-go tape directly, falling back to
+// encoding/json on CPUs that don't meet simdjson-go's AVX2 requirement.
+package prayers
+
+import (
+	"encoding/json"
+
+	"github.com/klauspost/cpuid/v2"
+	"github.com/minio/simdjson-go"
+)
+
+// useSIMD is decided once at init time: simdjson-go requires AVX2, and
+// probing cpuid per-call would just waste cycles on the fallback path.
+var useSIMD = cpuid.CPU.Supports(cpuid.AVX2)
+
+// Prayer mirrors the JSON shape the stocktake CLI sends upstream.
+type Prayer struct {
+	Sender   string `json:"Sender"`
+	Location string `json:"Location"`
+	Code     string `json:"Code"`
+	Soh      int    `json:"Soh"`
+}
+
+func New(sender, loc, code string, soh int) Prayer {
+	return Prayer{
+		Sender:   sender,
+		Location: loc,
+		Code:     code,
+		Soh:      soh,
+	}
+}
+
+// Marshal emits a single prayer. Plain encoding/json: batches are what's
+// worth accelerating, not one-off emission.
+func Marshal(p Prayer) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// Unmarshal decodes a single prayer, e.g. as a per-row fallback when a
+// batch can't be decoded as a whole. Plain encoding/json for the same
+// reason Marshal is: there's nothing here for simdjson-go to accelerate.
+func Unmarshal(buf []byte) (Prayer, error) {
+	var p Prayer
+	err := json.Unmarshal(buf, &p)
+	return p, err
+}
+
+// ParsePrayerBatch decodes a JSON array of prayers, e.g. the body of a
+// replayed peer log or an inbound ingest batch. It prefers simdjson-go's
+// tape walk (no intermediate map[string]interface{} per element) and
+// falls back to encoding/json on CPUs simdjson-go doesn't support.
+func ParsePrayerBatch(buf []byte) ([]Prayer, error) {
+	if useSIMD {
+		prayers, err := parseBatchSIMD(buf)
+		if err == nil {
+			return prayers, nil
+		}
+		// Fall through to encoding/json rather than surface a parse
+		// error that might just be a simdjson-go edge case.
+	}
+	return parseBatchFallback(buf)
+}
+
+func parseBatchFallback(buf []byte) ([]Prayer, error) {
+	var prayers []Prayer
+	if err := json.Unmarshal(buf, &prayers); err != nil {
+		return nil, err
+	}
+	return prayers, nil
+}
+
+func parseBatchSIMD(buf []byte) ([]Prayer, error) {
+	parsed, err := simdjson.Parse(buf, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := parsed.Iter()
+	iter.AdvanceInto()
+
+	_, root, err := iter.Root(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	arr, err := root.Array(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj simdjson.Object
+	var elemIter simdjson.Iter
+	var prayers []Prayer
+
+	var rangeErr error
+	arr.ForEach(func(i simdjson.Iter) {
+		if rangeErr != nil {
+			return
+		}
+		p, err := decodeObject(i, &obj, &elemIter)
+		if err != nil {
+			rangeErr = err
+			return
+		}
+		prayers = append(prayers, p)
+	})
+	if rangeErr != nil {
+		return nil, rangeErr
+	}
+
+	return prayers, nil
+}
+
+// decodeObject walks one object's key/value pairs directly off the tape,
+// skipping the map[string]interface{} that Object.Map would allocate.
+func decodeObject(i simdjson.Iter, obj *simdjson.Object, elem *simdjson.Iter) (Prayer, error) {
+	var p Prayer
+
+	o, err := i.Object(obj)
+	if err != nil {
+		return p, err
+	}
+
+	for {
+		name, t, err := o.NextElement(elem)
+		if err != nil {
+			return p, err
+		}
+		if t == simdjson.TypeNone {
+			break
+		}
+
+		switch name {
+		case "Sender":
+			p.Sender, err = elem.StringCvt()
+		case "Location":
+			p.Location, err = elem.StringCvt()
+		case "Code":
+			p.Code, err = elem.StringCvt()
+		case "Soh":
+			var n int64
+			n, err = elem.Int()
+			p.Soh = int(n)
+		}
+		if err != nil {
+			return p, err
+		}
+	}
+
+	return p, nil
+}
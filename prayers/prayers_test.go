@@ -0,0 +1,90 @@
+package prayers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustMarshalBatch(tb testing.TB, n int) []byte {
+	tb.Helper()
+	batch := make([]Prayer, n)
+	for i := range batch {
+		batch[i] = New("bench-host", "A1", "SKU0001", i)
+	}
+	buf, err := json.Marshal(batch)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return buf
+}
+
+func TestParsePrayerBatchRoundTrip(t *testing.T) {
+	want := []Prayer{
+		New("host-a", "A1", "SKU0001", 4),
+		New("host-b", "W12", "SKU0002", 99),
+	}
+	buf, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParsePrayerBatch(buf)
+	if err != nil {
+		t.Fatalf("ParsePrayerBatch: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d prayers, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("prayer %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParsePrayerBatchFallbackMatchesSIMD(t *testing.T) {
+	buf := mustMarshalBatch(t, 256)
+
+	simdResult, err := parseBatchSIMD(buf)
+	if err != nil {
+		t.Skipf("simdjson-go not usable on this CPU: %v", err)
+	}
+
+	fallbackResult, err := parseBatchFallback(buf)
+	if err != nil {
+		t.Fatalf("parseBatchFallback: %v", err)
+	}
+
+	if len(simdResult) != len(fallbackResult) {
+		t.Fatalf("got %d SIMD prayers, %d fallback prayers", len(simdResult), len(fallbackResult))
+	}
+	for i := range simdResult {
+		if simdResult[i] != fallbackResult[i] {
+			t.Errorf("prayer %d = %+v (SIMD), %+v (fallback)", i, simdResult[i], fallbackResult[i])
+		}
+	}
+}
+
+func BenchmarkParsePrayerBatch(b *testing.B) {
+	buf := mustMarshalBatch(b, 10_000)
+	b.ResetTimer()
+	b.SetBytes(int64(len(buf)))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := ParsePrayerBatch(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParsePrayerBatchFallback(b *testing.B) {
+	buf := mustMarshalBatch(b, 10_000)
+	b.ResetTimer()
+	b.SetBytes(int64(len(buf)))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := parseBatchFallback(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestWALRetryableBusyAndLocked(t *testing.T) {
+	for _, code := range []sqlite3.ErrNo{sqlite3.ErrBusy, sqlite3.ErrLocked} {
+		err := sqlite3.Error{Code: code}
+		if !walRetryable(err) {
+			t.Errorf("walRetryable(%v) = false, want true", err)
+		}
+	}
+}
+
+func TestWALRetryableNonTransient(t *testing.T) {
+	if walRetryable(sqlite3.Error{Code: sqlite3.ErrConstraint}) {
+		t.Error("walRetryable(ErrConstraint) = true, want false")
+	}
+	if walRetryable(errors.New("not a sqlite error")) {
+		t.Error("walRetryable(plain error) = true, want false")
+	}
+}